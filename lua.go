@@ -18,6 +18,7 @@ local key          = KEYS[1]
 local now          = tonumber(ARGV[1]) -- current unix time in nanoseconds
 local defmaxtokens = tonumber(ARGV[2]) -- default tokens per interval, only used if no value already exists for the key
 local definterval  = tonumber(ARGV[3]) -- interval in nanoseconds, only used if no value already exists for the key
+local requested    = tonumber(ARGV[4]) or 1 -- number of tokens requested by this call
 
 -- hgetall gets all the fields as a lua table.
 local hgetall = function (key)
@@ -115,8 +116,8 @@ if lasttick < currtick then
 	redis.call(C_EXPIRE, key, ttl(interval))
 end
 
-if tokens > 0 then
-  tokens = tokens - 1
+if tokens >= requested then
+  tokens = tokens - requested
   redis.call(C_HSET, key, F_TOKENS, tokens)
 	redis.call(C_EXPIRE, key, ttl(interval))
   return {maxtokens, tokens, nexttime, true}
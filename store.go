@@ -5,6 +5,8 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -21,7 +23,12 @@ const (
 	// weekSeconds is the number of seconds in a week.
 	weekSeconds = 60 * 60 * 24 * 7
 
+	// healthCheckInterval is how often the health check goroutine pings Redis
+	// to decide whether to route traffic to the Fallback store.
+	healthCheckInterval = 10 * time.Second
+
 	// Common Redis commands
+	cmdDEL     = "DEL"
 	cmdEXPIRE  = "EXPIRE"
 	cmdHINCRBY = "HINCRBY"
 	cmdHMGET   = "HMGET"
@@ -29,13 +36,45 @@ const (
 	cmdPING    = "PING"
 )
 
-var _ limiter.Store = (*store)(nil)
+var _ limiter.Store = (*Store)(nil)
+
+// Client is the minimal set of redis operations required to back the store.
+// Both *redis.Client and *redis.ClusterClient satisfy this interface, which
+// allows the store to be used against a single node or a sharded cluster
+// deployment. Since the Lua script only ever touches a single KEY, it is
+// already cluster-safe.
+type Client interface {
+	redis.Scripter
 
-type store struct {
-	tokens    uint64
-	interval  time.Duration
-	client    *redis.Client
-	luaScript *redis.Script
+	Do(ctx context.Context, args ...interface{}) *redis.Cmd
+	Close() error
+}
+
+type Store struct {
+	tokens   uint64
+	interval time.Duration
+	prefix   string
+	client   Client
+
+	// scriptSHA holds the SHA1 of luaTemplate as loaded into Redis. It's
+	// preloaded at construction time so the hot path can issue EVALSHA instead
+	// of sending the full script body on every call. reloadMu guards against a
+	// thundering herd of reloads when many goroutines observe a NOSCRIPT error
+	// at the same time, e.g. after a SCRIPT FLUSH or a failover to a replica
+	// that never saw the script. reloadGen counts completed reloads, so a
+	// goroutine that waited on reloadMu can tell that a reload already happened.
+	scriptSHA atomic.Value // string
+	reloadMu  sync.Mutex
+	reloadGen uint32
+
+	// fallback, if configured, receives Take/Get/Set/Burst traffic whenever
+	// Redis is unreachable. redisDown is flipped by the health check goroutine
+	// and read before each of those operations; Reset has no Fallback
+	// equivalent and always talks to Redis directly. cancelHealthCheck stops
+	// the health check goroutine on Close.
+	fallback          limiter.Store
+	redisDown         uint32
+	cancelHealthCheck context.CancelFunc
 
 	stopped uint32
 }
@@ -51,21 +90,49 @@ type Config struct {
 	// default value is 1 second.
 	Interval time.Duration
 
+	// Prefix is prepended to every key before it is sent to Redis. This allows
+	// multiple limiter namespaces to share a single Redis instance without their
+	// keys colliding. The default value is no prefix.
+	Prefix string
+
+	// Fallback, if provided, is used to serve Take, Get, Set, and Burst while
+	// Redis is unreachable, rather than failing those calls. A background
+	// goroutine pings Redis on an interval and routes traffic back once it
+	// becomes reachable again. A typical Fallback is an in-memory store such as
+	// memorystore, so the limiter degrades gracefully instead of failing open or
+	// closed during a Redis outage. Keys are passed to Fallback with Prefix
+	// already applied, so a single Fallback instance may safely be shared
+	// across multiple Stores configured with different Prefix values.
+	Fallback limiter.Store
+
 	// Redis client options
 	RedisOptions *redis.Options
 }
 
 // New uses a Redis instance to back a rate limiter that to limit the number of
-// permitted events over an interval.
-func New(c *Config) (limiter.Store, error) {
+// permitted events over an interval. It returns the concrete *Store, rather
+// than the limiter.Store interface, so that callers can reach the
+// redisstore-specific TakeN and Reset methods in addition to the
+// limiter.Store methods.
+func New(c *Config) (*Store, error) {
 	client := redis.NewClient(c.RedisOptions)
 
 	return NewWithClient(c, client)
 }
 
-// NewWithClient creates a new limiter using the given redis pool. Use this to
-// customize lower-level details about the pool.
-func NewWithClient(c *Config, client *redis.Client) (limiter.Store, error) {
+// NewWithClient creates a new limiter using the given redis client. Use this
+// to customize lower-level details about the connection.
+func NewWithClient(c *Config, client *redis.Client) (*Store, error) {
+	return newStore(c, client)
+}
+
+// NewWithClusterClient creates a new limiter using the given redis cluster
+// client. Use this when Redis is deployed as a sharded cluster.
+func NewWithClusterClient(c *Config, client *redis.ClusterClient) (*Store, error) {
+	return newStore(c, client)
+}
+
+func newStore(c *Config, client Client) (*Store, error) {
 	if c == nil {
 		c = new(Config)
 	}
@@ -80,23 +147,141 @@ func NewWithClient(c *Config, client *redis.Client) (limiter.Store, error) {
 		interval = c.Interval
 	}
 
-	luaScript := redis.NewScript(luaTemplate)
+	s := &Store{
+		tokens:   tokens,
+		interval: interval,
+		prefix:   c.Prefix,
+		client:   client,
+	}
+
+	sha, err := client.ScriptLoad(context.Background(), luaTemplate).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load script: %w", err)
+	}
+	s.scriptSHA.Store(sha)
 
-	s := &store{
-		tokens:    tokens,
-		interval:  interval,
-		client:    client,
-		luaScript: luaScript,
+	if c.Fallback != nil {
+		s.fallback = c.Fallback
+
+		ctx, cancel := context.WithCancel(context.Background())
+		s.cancelHealthCheck = cancel
+		go s.healthCheck(ctx)
 	}
+
 	return s, nil
 }
 
+// healthCheck periodically pings Redis and flips redisDown so that Take,
+// Get, Set, and Burst route to the Fallback store while Redis is
+// unreachable, and route back once it recovers.
+func (s *Store) healthCheck(ctx context.Context) {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.client.Do(ctx, cmdPING).Err(); err != nil {
+				atomic.StoreUint32(&s.redisDown, 1)
+			} else {
+				atomic.StoreUint32(&s.redisDown, 0)
+			}
+		}
+	}
+}
+
+// prefixed applies the configured Prefix to the given key.
+func (s *Store) prefixed(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return s.prefix + key
+}
+
+// isNoScript returns true if the given error is a NOSCRIPT error, indicating
+// that Redis does not have the script cached (e.g. after a SCRIPT FLUSH or a
+// failover to a replica that never received it).
+func isNoScript(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT")
+}
+
+// reloadScript reloads luaTemplate into Redis and caches the resulting SHA1.
+// Concurrent callers block on reloadMu so only one SCRIPT LOAD is issued at a
+// time; gen is the value of reloadGen the caller saw before its NOSCRIPT, and
+// callers that were waiting on the lock while another reload completed simply
+// reuse the SHA1 the winner stored.
+func (s *Store) reloadScript(ctx context.Context, gen uint32) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	if atomic.LoadUint32(&s.reloadGen) != gen {
+		return nil
+	}
+
+	sha, err := s.client.ScriptLoad(ctx, luaTemplate).Result()
+	if err != nil {
+		return fmt.Errorf("failed to reload script: %w", err)
+	}
+	s.scriptSHA.Store(sha)
+	atomic.AddUint32(&s.reloadGen, 1)
+	return nil
+}
+
+// runScript evaluates luaTemplate via EVALSHA, reloading and falling back to
+// EVAL if Redis no longer has the script cached.
+func (s *Store) runScript(ctx context.Context, keys []string, args ...interface{}) ([]interface{}, error) {
+	sha, _ := s.scriptSHA.Load().(string)
+	gen := atomic.LoadUint32(&s.reloadGen)
+
+	res, err := s.client.EvalSha(ctx, sha, keys, args...).Slice()
+	if isNoScript(err) {
+		if err := s.reloadScript(ctx, gen); err != nil {
+			return nil, err
+		}
+		res, err = s.client.Eval(ctx, luaTemplate, keys, args...).Slice()
+	}
+	return res, err
+}
+
+// down reports whether Redis is currently unreachable and a Fallback store
+// is configured to absorb traffic in its place.
+func (s *Store) down() bool {
+	return s.fallback != nil && atomic.LoadUint32(&s.redisDown) == 1
+}
+
 // Take attempts to remove a token from the named key. If the take is
 // successful, it returns true, otherwise false. It also returns the configured
 // limit, remaining tokens, and reset time, if one was found. Any errors
 // connecting to the store or parsing the return value are considered failures
 // and fail the take.
-func (s *store) Take(ctx context.Context, key string) (limit uint64, remaining uint64, next uint64, ok bool, retErr error) {
+func (s *Store) Take(ctx context.Context, key string) (limit uint64, remaining uint64, next uint64, ok bool, retErr error) {
+	// If the store is stopped, all requests are rejected.
+	if atomic.LoadUint32(&s.stopped) == 1 {
+		retErr = limiter.ErrStopped
+		return
+	}
+
+	if s.down() {
+		return s.fallback.Take(ctx, s.prefixed(key))
+	}
+	return s.takeN(ctx, key, 1)
+}
+
+// TakeN attempts to remove n tokens from the named key in a single round
+// trip. The take is all-or-nothing: if fewer than n tokens are available,
+// nothing is decremented and ok is false. This allows callers to charge
+// variable-cost operations without performing n individual Take calls.
+//
+// TakeN has no Fallback equivalent, since limiter.Store does not define a
+// weighted take. If Redis is unreachable and a Fallback is configured, TakeN
+// still fails rather than silently charging the wrong number of tokens.
+func (s *Store) TakeN(ctx context.Context, key string, n uint64) (limit uint64, remaining uint64, next uint64, ok bool, retErr error) {
+	return s.takeN(ctx, key, n)
+}
+
+func (s *Store) takeN(ctx context.Context, key string, n uint64) (limit uint64, remaining uint64, next uint64, ok bool, retErr error) {
 	// If the store is stopped, all requests are rejected.
 	if atomic.LoadUint32(&s.stopped) == 1 {
 		retErr = limiter.ErrStopped
@@ -110,7 +295,8 @@ func (s *store) Take(ctx context.Context, key string) (limit uint64, remaining u
 	nowStr := strconv.FormatUint(now, 10)
 	tokensStr := strconv.FormatUint(s.tokens, 10)
 	intervalStr := strconv.FormatInt(s.interval.Nanoseconds(), 10)
-	a, err := s.luaScript.Run(ctx, s.client, []string{key}, nowStr, tokensStr, intervalStr).Slice()
+	nStr := strconv.FormatUint(n, 10)
+	a, err := s.runScript(ctx, []string{s.prefixed(key)}, nowStr, tokensStr, intervalStr, nStr)
 	if err != nil {
 		retErr = fmt.Errorf("failed to run script: %w", err)
 		return
@@ -127,14 +313,18 @@ func (s *store) Take(ctx context.Context, key string) (limit uint64, remaining u
 
 // Get gets the current limit and remaining tokens for the key. It does not
 // reduce or reset any counters.
-func (s *store) Get(ctx context.Context, key string) (limit, remaining uint64, retErr error) {
+func (s *Store) Get(ctx context.Context, key string) (limit, remaining uint64, retErr error) {
 	// If the store is stopped, all requests are rejected.
 	if atomic.LoadUint32(&s.stopped) == 1 {
 		retErr = limiter.ErrStopped
 		return
 	}
 
-	result, err := s.client.Do(ctx, cmdHMGET, key, fieldMaxTokens, fieldTokens).Slice()
+	if s.down() {
+		return s.fallback.Get(ctx, s.prefixed(key))
+	}
+
+	result, err := s.client.Do(ctx, cmdHMGET, s.prefixed(key), fieldMaxTokens, fieldTokens).Slice()
 	if err != nil {
 		retErr = fmt.Errorf("failed to get key: %w", err)
 		return
@@ -155,14 +345,19 @@ func (s *store) Get(ctx context.Context, key string) (limit, remaining uint64, r
 }
 
 // Set sets the key's limit to the provided value and interval.
-func (s *store) Set(ctx context.Context, key string, tokens uint64, interval time.Duration) (retErr error) {
+func (s *Store) Set(ctx context.Context, key string, tokens uint64, interval time.Duration) (retErr error) {
 	// If the store is stopped, all requests are rejected.
 	if atomic.LoadUint32(&s.stopped) == 1 {
 		retErr = limiter.ErrStopped
 		return
 	}
 
+	if s.down() {
+		return s.fallback.Set(ctx, s.prefixed(key), tokens, interval)
+	}
+
 	// Set configuration on the key.
+	key = s.prefixed(key)
 	tokensStr := strconv.FormatUint(tokens, 10)
 	intervalStr := strconv.FormatInt(interval.Nanoseconds(), 10)
 	if err := s.client.Do(ctx, cmdHSET, key,
@@ -185,14 +380,19 @@ func (s *store) Set(ctx context.Context, key string, tokens uint64, interval tim
 }
 
 // Burst adds the given tokens to the key's bucket.
-func (s *store) Burst(ctx context.Context, key string, tokens uint64) (retErr error) {
+func (s *Store) Burst(ctx context.Context, key string, tokens uint64) (retErr error) {
 	// If the store is stopped, all requests are rejected.
 	if atomic.LoadUint32(&s.stopped) == 1 {
 		retErr = limiter.ErrStopped
 		return
 	}
 
+	if s.down() {
+		return s.fallback.Burst(ctx, s.prefixed(key), tokens)
+	}
+
 	// Set configuration on the key.
+	key = s.prefixed(key)
 	tokensStr := strconv.FormatUint(tokens, 10)
 	if err := s.client.Do(ctx, cmdHINCRBY, key, fieldTokens, tokensStr).Err(); err != nil {
 		retErr = fmt.Errorf("failed to set key: %w", err)
@@ -209,14 +409,41 @@ func (s *store) Burst(ctx context.Context, key string, tokens uint64) (retErr er
 	return
 }
 
+// Reset deletes the key's bucket entirely, clearing any configured limit and
+// remaining tokens. A subsequent Take will re-initialize the bucket using the
+// store's default Tokens and Interval, as if the key had never been seen.
+//
+// Reset has no Fallback equivalent, since limiter.Store does not define a
+// reset operation. It always talks to Redis directly, so it fails while
+// Redis is unreachable even if a Fallback is absorbing Take/Get/Set/Burst.
+func (s *Store) Reset(ctx context.Context, key string) (retErr error) {
+	// If the store is stopped, all requests are rejected.
+	if atomic.LoadUint32(&s.stopped) == 1 {
+		retErr = limiter.ErrStopped
+		return
+	}
+
+	if err := s.client.Do(ctx, cmdDEL, s.prefixed(key)).Err(); err != nil {
+		retErr = fmt.Errorf("failed to delete key: %w", err)
+		return
+	}
+
+	return
+}
+
 // Close stops the memory limiter and cleans up any outstanding sessions. You
 // should always call CloseWithContext() as it releases any open network
 // connections.
-func (s *store) Close(_ context.Context) error {
+func (s *Store) Close(_ context.Context) error {
 	if !atomic.CompareAndSwapUint32(&s.stopped, 0, 1) {
 		return nil
 	}
 
+	// Stop the health check goroutine, if one was started for a Fallback.
+	if s.cancelHealthCheck != nil {
+		s.cancelHealthCheck()
+	}
+
 	// Close the connection pool.
 	if err := s.client.Close(); err != nil {
 		return fmt.Errorf("failed to close client: %w", err)
@@ -0,0 +1,347 @@
+package redisstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sethvargo/go-limiter"
+)
+
+// fakeLimiterStore is a minimal limiter.Store used to observe which keys a
+// Store delegates to Config.Fallback.
+type fakeLimiterStore struct {
+	mu        sync.Mutex
+	takeCalls []string
+}
+
+var _ limiter.Store = (*fakeLimiterStore)(nil)
+
+func (f *fakeLimiterStore) Take(ctx context.Context, key string) (uint64, uint64, uint64, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.takeCalls = append(f.takeCalls, key)
+	return 99, 98, 0, true, nil
+}
+
+func (f *fakeLimiterStore) Get(ctx context.Context, key string) (uint64, uint64, error) {
+	return 0, 0, nil
+}
+
+func (f *fakeLimiterStore) Set(ctx context.Context, key string, tokens uint64, interval time.Duration) error {
+	return nil
+}
+
+func (f *fakeLimiterStore) Burst(ctx context.Context, key string, tokens uint64) error {
+	return nil
+}
+
+func (f *fakeLimiterStore) Close(ctx context.Context) error { return nil }
+
+// fakeClient is a minimal in-memory stand-in for Client, used to exercise
+// store logic without a real Redis connection. Eval/EvalSha do not
+// replicate the Lua script's token-bucket math faithfully; they only track
+// enough state (current tokens per key) to drive TakeN's all-or-nothing
+// semantics, which is all these tests need.
+type fakeClient struct {
+	mu      sync.Mutex
+	sha     string
+	buckets map[string]uint64
+	hashes  map[string]map[string]string
+
+	forceNoScriptOnce bool
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		sha:     "fakesha",
+		buckets: make(map[string]uint64),
+		hashes:  make(map[string]map[string]string),
+	}
+}
+
+func (f *fakeClient) ScriptLoad(ctx context.Context, script string) *redis.StringCmd {
+	cmd := redis.NewStringCmd(ctx)
+	cmd.SetVal(f.sha)
+	return cmd
+}
+
+func (f *fakeClient) ScriptExists(ctx context.Context, hashes ...string) *redis.BoolSliceCmd {
+	cmd := redis.NewBoolSliceCmd(ctx)
+	cmd.SetVal([]bool{true})
+	return cmd
+}
+
+func (f *fakeClient) evalTakeN(ctx context.Context, keys []string, args ...interface{}) *redis.Cmd {
+	cmd := redis.NewCmd(ctx)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := keys[0]
+	maxTokens, _ := strconv.ParseUint(args[1].(string), 10, 64)
+
+	n := uint64(1)
+	if len(args) > 3 {
+		n, _ = strconv.ParseUint(args[3].(string), 10, 64)
+	}
+
+	tokens, ok := f.buckets[key]
+	if !ok {
+		tokens = maxTokens
+	}
+
+	if tokens >= n {
+		tokens -= n
+		f.buckets[key] = tokens
+		cmd.SetVal([]interface{}{int64(maxTokens), int64(tokens), int64(0), true})
+		return cmd
+	}
+
+	f.buckets[key] = tokens
+	cmd.SetVal([]interface{}{int64(maxTokens), int64(tokens), int64(0), nil})
+	return cmd
+}
+
+func (f *fakeClient) Eval(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd {
+	return f.evalTakeN(ctx, keys, args...)
+}
+
+func (f *fakeClient) EvalSha(ctx context.Context, sha1 string, keys []string, args ...interface{}) *redis.Cmd {
+	f.mu.Lock()
+	force := f.forceNoScriptOnce
+	f.forceNoScriptOnce = false
+	f.mu.Unlock()
+
+	if force {
+		cmd := redis.NewCmd(ctx)
+		cmd.SetErr(errors.New("NOSCRIPT No matching script. Please use EVAL."))
+		return cmd
+	}
+	return f.evalTakeN(ctx, keys, args...)
+}
+
+func (f *fakeClient) Do(ctx context.Context, args ...interface{}) *redis.Cmd {
+	cmd := redis.NewCmd(ctx)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(args) == 0 {
+		cmd.SetErr(errors.New("fakeClient: no command given"))
+		return cmd
+	}
+
+	switch args[0] {
+	case cmdPING:
+		cmd.SetVal("PONG")
+	case cmdHMGET:
+		key := args[1].(string)
+		h := f.hashes[key]
+		result := make([]interface{}, 0, len(args)-2)
+		for _, field := range args[2:] {
+			if v, ok := h[field.(string)]; ok {
+				result = append(result, v)
+			} else {
+				result = append(result, nil)
+			}
+		}
+		cmd.SetVal(result)
+	case cmdHSET:
+		key := args[1].(string)
+		h := f.hashes[key]
+		if h == nil {
+			h = make(map[string]string)
+			f.hashes[key] = h
+		}
+		for i := 2; i+1 < len(args); i += 2 {
+			h[args[i].(string)] = args[i+1].(string)
+		}
+		cmd.SetVal("OK")
+	case cmdHINCRBY:
+		key := args[1].(string)
+		h := f.hashes[key]
+		if h == nil {
+			h = make(map[string]string)
+			f.hashes[key] = h
+		}
+		field := args[2].(string)
+		delta, _ := strconv.ParseInt(args[3].(string), 10, 64)
+		cur, _ := strconv.ParseInt(h[field], 10, 64)
+		cur += delta
+		h[field] = strconv.FormatInt(cur, 10)
+		cmd.SetVal(cur)
+	case cmdEXPIRE:
+		cmd.SetVal(int64(1))
+	case cmdDEL:
+		key := args[1].(string)
+		delete(f.hashes, key)
+		delete(f.buckets, key)
+		cmd.SetVal(int64(1))
+	default:
+		cmd.SetErr(fmt.Errorf("fakeClient: unsupported command %v", args[0]))
+	}
+	return cmd
+}
+
+func (f *fakeClient) Close() error { return nil }
+
+func TestTake_NoScriptReload(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	fc := newFakeClient()
+
+	s, err := newStore(&Config{Tokens: 2, Interval: time.Minute}, fc)
+	if err != nil {
+		t.Fatalf("newStore: %v", err)
+	}
+
+	// Simulate a SCRIPT FLUSH: the next EVALSHA call returns NOSCRIPT, which
+	// should trigger a reload and a direct EVAL, not a hard failure.
+	fc.forceNoScriptOnce = true
+
+	_, remaining, _, ok, err := s.Take(ctx, "k")
+	if err != nil {
+		t.Fatalf("Take after NOSCRIPT: %v", err)
+	}
+	if !ok {
+		t.Fatalf("Take after NOSCRIPT: got ok=false, want true")
+	}
+	if remaining != 1 {
+		t.Fatalf("remaining = %d, want 1", remaining)
+	}
+}
+
+func TestTakeN(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	fc := newFakeClient()
+
+	s, err := newStore(&Config{Tokens: 5, Interval: time.Minute}, fc)
+	if err != nil {
+		t.Fatalf("newStore: %v", err)
+	}
+
+	_, remaining, _, ok, err := s.TakeN(ctx, "k", 3)
+	if err != nil {
+		t.Fatalf("TakeN: %v", err)
+	}
+	if !ok {
+		t.Fatalf("TakeN(3): got ok=false, want true")
+	}
+	if remaining != 2 {
+		t.Fatalf("remaining = %d, want 2", remaining)
+	}
+
+	// Only 2 tokens remain; an all-or-nothing request for 3 must fail without
+	// mutating the bucket.
+	_, remaining, _, ok, err = s.TakeN(ctx, "k", 3)
+	if err != nil {
+		t.Fatalf("TakeN: %v", err)
+	}
+	if ok {
+		t.Fatalf("TakeN(3) with 2 remaining: got ok=true, want false")
+	}
+	if remaining != 2 {
+		t.Fatalf("remaining after failed TakeN = %d, want unchanged 2", remaining)
+	}
+}
+
+func TestReset(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	fc := newFakeClient()
+
+	s, err := newStore(&Config{Tokens: 5, Interval: time.Minute}, fc)
+	if err != nil {
+		t.Fatalf("newStore: %v", err)
+	}
+
+	if err := s.Set(ctx, "k", 10, time.Minute); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	if limit, _, err := s.Get(ctx, "k"); err != nil {
+		t.Fatalf("Get: %v", err)
+	} else if limit != 10 {
+		t.Fatalf("limit = %d, want 10", limit)
+	}
+
+	if err := s.Reset(ctx, "k"); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+
+	if limit, remaining, err := s.Get(ctx, "k"); err != nil {
+		t.Fatalf("Get after Reset: %v", err)
+	} else if limit != 0 || remaining != 0 {
+		t.Fatalf("Get after Reset = (%d, %d), want (0, 0)", limit, remaining)
+	}
+}
+
+func TestConfig_Prefix(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	fc := newFakeClient()
+
+	s, err := newStore(&Config{Tokens: 5, Interval: time.Minute, Prefix: "ns1:"}, fc)
+	if err != nil {
+		t.Fatalf("newStore: %v", err)
+	}
+
+	if _, _, _, ok, err := s.Take(ctx, "k"); err != nil || !ok {
+		t.Fatalf("Take: ok=%v, err=%v", ok, err)
+	}
+
+	if _, ok := fc.buckets["ns1:k"]; !ok {
+		t.Fatalf("expected fake client to see prefixed key %q, got keys %v", "ns1:k", fc.buckets)
+	}
+	if _, ok := fc.buckets["k"]; ok {
+		t.Fatalf("expected fake client not to see unprefixed key %q", "k")
+	}
+}
+
+func TestFallback(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	fc := newFakeClient()
+	fl := &fakeLimiterStore{}
+
+	s, err := newStore(&Config{
+		Tokens:   5,
+		Interval: time.Minute,
+		Prefix:   "ns1:",
+		Fallback: fl,
+	}, fc)
+	if err != nil {
+		t.Fatalf("newStore: %v", err)
+	}
+
+	// Force the down path without waiting on the health check goroutine's
+	// ticker.
+	s.redisDown = 1
+
+	limit, _, _, ok, err := s.Take(ctx, "k")
+	if err != nil {
+		t.Fatalf("Take while down: %v", err)
+	}
+	if !ok || limit != 99 {
+		t.Fatalf("Take while down = (limit=%d, ok=%v), want fallback's (99, true)", limit, ok)
+	}
+
+	if len(fl.takeCalls) != 1 || fl.takeCalls[0] != "ns1:k" {
+		t.Fatalf("fallback saw Take calls %v, want [\"ns1:k\"]", fl.takeCalls)
+	}
+	if len(fc.buckets) != 0 {
+		t.Fatalf("real client saw buckets %v, want none touched while down", fc.buckets)
+	}
+}